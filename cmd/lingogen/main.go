@@ -0,0 +1,455 @@
+// Command lingogen generates MarshalIAP/UnmarshalIAP/IAPSize methods for
+// lingo command payloads.
+//
+// It scans a package for "lingos groups": structs whose every field carries
+// an `id:"..."` tag, the same convention consumed by ipod.RegisterLingos.
+// For each field of such a group it looks up the field's type declaration
+// in the same package and emits the iAP wire encoding for it, writing the
+// result to <type>_iapgen.go (the type name in lower case) in the scanned
+// package directory.
+//
+// Unlike encoding/binary.Size, lingogen understands variable-length
+// trailing fields via an `iap:"..."` struct tag on the payload's own
+// fields:
+//
+//	Name string `iap:"nul"`          // NUL-terminated string
+//	Data []byte `iap:"lenprefix=1"`  // 1/2/4-byte big-endian length prefix
+//	Rows []Row  `iap:"count=NumRows"` // repeat count taken from another field
+//
+// Fields without an `iap` tag are assumed fixed-size and are marshaled with
+// encoding/binary, matching the style already used for LingoCmdID, except
+// a field whose type is itself a generated payload type, which is encoded
+// by calling that type's own MarshalIAP/UnmarshalIAP/IAPSize.
+//
+// Typical usage, from a lingos package:
+//
+//	//go:generate go run github.com/xjhsxjh/ipod/cmd/lingogen
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("lingogen: ")
+
+	dir := flag.String("dir", ".", "package directory to scan")
+	flag.Parse()
+
+	if err := run(*dir); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(dir string) error {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, nil, parser.ParseComments)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", dir, err)
+	}
+
+	for pkgName, pkg := range pkgs {
+		structs := collectStructs(pkg)
+		targets := collectTargets(structs)
+		if len(targets) == 0 {
+			continue
+		}
+		seenTargets := make(map[string]bool, len(targets))
+		for _, name := range targets {
+			seenTargets[name] = true
+		}
+
+		byFile := make(map[string][]string) // file -> generated type names, for logging only
+		for _, name := range targets {
+			st, ok := structs[name]
+			if !ok {
+				return fmt.Errorf("package %s: lingos group references undeclared type %s", pkgName, name)
+			}
+
+			code, err := genType(name, st, seenTargets)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+
+			outPath := filepath.Join(dir, strings.ToLower(name)+"_iapgen.go")
+			if err := writeGenerated(outPath, pkgName, code); err != nil {
+				return err
+			}
+			byFile[outPath] = append(byFile[outPath], name)
+		}
+
+		for f, names := range byFile {
+			log.Printf("%s: %s", f, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// collectStructs indexes every struct type declared in pkg by name.
+func collectStructs(pkg *ast.Package) map[string]*ast.StructType {
+	out := make(map[string]*ast.StructType)
+	for _, f := range pkg.Files {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts, ok := spec.(*ast.TypeSpec)
+				if !ok {
+					continue
+				}
+				if st, ok := ts.Type.(*ast.StructType); ok {
+					out[ts.Name.Name] = st
+				}
+			}
+		}
+	}
+	return out
+}
+
+// collectTargets finds "lingos group" structs -- structs whose every field
+// has an `id:"..."` tag -- and returns the (deduplicated) list of payload
+// types that need generated codecs: the lingos groups' own field types,
+// plus, recursively, any locally-declared struct type reached through
+// them (e.g. the element type of a `count=` slice). Without the recursive
+// step, a payload like GetIndexedPlayingTrackInfo's `Rows []TrackInfo
+// `iap:"count=Count"`` would generate a call to TrackInfo.MarshalIAP
+// without ever generating TrackInfo's own methods.
+func collectTargets(structs map[string]*ast.StructType) []string {
+	seen := make(map[string]bool)
+	var targets []string
+	var queue []string
+
+	enqueue := func(name string) {
+		if name == "" || seen[name] {
+			return
+		}
+		if _, ok := structs[name]; !ok {
+			return // not a locally-declared struct (e.g. a basic type)
+		}
+		seen[name] = true
+		targets = append(targets, name)
+		queue = append(queue, name)
+	}
+
+	for _, st := range structs {
+		if !isLingosGroup(st) {
+			continue
+		}
+		for _, f := range st.Fields.List {
+			enqueue(exprTypeName(f.Type))
+		}
+	}
+
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, f := range structs[name].Fields.List {
+			for _, n := range referencedTypeNames(f.Type) {
+				enqueue(n)
+			}
+		}
+	}
+
+	return targets
+}
+
+// referencedTypeNames returns the locally-declared type name(s) a field
+// expression could resolve to: the identifier itself, or -- for pointers
+// and arrays/slices -- the identifier of what it points to or holds.
+func referencedTypeNames(e ast.Expr) []string {
+	switch t := e.(type) {
+	case *ast.Ident:
+		return []string{t.Name}
+	case *ast.StarExpr:
+		return referencedTypeNames(t.X)
+	case *ast.ArrayType:
+		return referencedTypeNames(t.Elt)
+	default:
+		return nil
+	}
+}
+
+func isLingosGroup(st *ast.StructType) bool {
+	if st.Fields == nil || len(st.Fields.List) == 0 {
+		return false
+	}
+	for _, f := range st.Fields.List {
+		if f.Tag == nil {
+			return false
+		}
+		tag := reflect.StructTag(strings.Trim(f.Tag.Value, "`"))
+		if _, ok := tag.Lookup("id"); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func exprTypeName(e ast.Expr) string {
+	switch t := e.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.StarExpr:
+		return exprTypeName(t.X)
+	default:
+		return ""
+	}
+}
+
+// field describes one struct field in terms lingogen can marshal.
+type field struct {
+	Name     string
+	GoType   string // e.g. "uint32", "[]byte", "string", "[4]byte", "TrackInfo"
+	Elem     string // element type for slices
+	Variable string // "", "nul", "lenprefix", "count"
+	Width    int    // prefix width in bytes, for lenprefix
+	CountOf  string // referenced field name, for count
+	Nested   bool   // GoType is itself a generated payload type; call its MarshalIAP/UnmarshalIAP/IAPSize instead of encoding/binary
+}
+
+// genType generates MarshalIAP/UnmarshalIAP/IAPSize for the struct st
+// named name. targets is the full set of types lingogen is generating
+// methods for (see collectTargets): a plain (non-slice) field whose type
+// is in targets is itself a generated payload, so it's encoded by calling
+// its own methods rather than encoding/binary.
+func genType(name string, st *ast.StructType, targets map[string]bool) (string, error) {
+	var fields []field
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			return "", fmt.Errorf("embedded fields are not supported (%s)", name)
+		}
+		opt, err := parseIAPTag(f.Tag)
+		if err != nil {
+			return "", fmt.Errorf("field %s: %w", f.Names[0].Name, err)
+		}
+		for _, n := range f.Names {
+			fl := field{Name: n.Name, Variable: opt.kind, Width: opt.width, CountOf: opt.countOf}
+			switch t := f.Type.(type) {
+			case *ast.Ident:
+				fl.GoType = t.Name
+				if opt.kind == "" && targets[t.Name] {
+					fl.Nested = true
+				}
+			case *ast.ArrayType:
+				elem := exprTypeName(t.Elt)
+				if t.Len == nil {
+					fl.GoType = "[]" + elem
+					fl.Elem = elem
+				} else {
+					lit, ok := t.Len.(*ast.BasicLit)
+					if !ok {
+						return "", fmt.Errorf("field %s: unsupported array length", n.Name)
+					}
+					fl.GoType = fmt.Sprintf("[%s]%s", lit.Value, elem)
+				}
+			default:
+				return "", fmt.Errorf("field %s: unsupported field type", n.Name)
+			}
+			fields = append(fields, fl)
+		}
+	}
+
+	needsBinary := false
+	for _, fl := range fields {
+		if (fl.Variable == "" && !fl.Nested) || fl.Variable == "lenprefix" {
+			needsBinary = true
+			break
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := genTmpl.Execute(&buf, struct {
+		Type        string
+		Fields      []field
+		NeedsBinary bool
+	}{name, fields, needsBinary}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+type iapTagOpts struct {
+	kind    string // "", "nul", "lenprefix", "count"
+	width   int
+	countOf string
+}
+
+// parseIAPTag parses the `iap:"..."` struct tag that marks a field as
+// variable-length. Recognized forms: "nul", "lenprefix=1|2|4",
+// "count=FieldName".
+func parseIAPTag(tag *ast.BasicLit) (iapTagOpts, error) {
+	if tag == nil {
+		return iapTagOpts{}, nil
+	}
+	raw := reflect.StructTag(strings.Trim(tag.Value, "`")).Get("iap")
+	if raw == "" {
+		return iapTagOpts{}, nil
+	}
+
+	switch {
+	case raw == "nul":
+		return iapTagOpts{kind: "nul"}, nil
+	case strings.HasPrefix(raw, "lenprefix="):
+		w, err := strconv.Atoi(strings.TrimPrefix(raw, "lenprefix="))
+		if err != nil || (w != 1 && w != 2 && w != 4) {
+			return iapTagOpts{}, fmt.Errorf("iap tag %q: lenprefix width must be 1, 2 or 4", raw)
+		}
+		return iapTagOpts{kind: "lenprefix", width: w}, nil
+	case strings.HasPrefix(raw, "count="):
+		return iapTagOpts{kind: "count", countOf: strings.TrimPrefix(raw, "count=")}, nil
+	default:
+		return iapTagOpts{}, fmt.Errorf("unrecognized iap tag %q", raw)
+	}
+}
+
+func writeGenerated(path, pkgName, body string) error {
+	src := fmt.Sprintf("// Code generated by lingogen. DO NOT EDIT.\n\npackage %s\n\n%s", pkgName, body)
+	formatted, err := format.Source([]byte(src))
+	if err != nil {
+		return fmt.Errorf("format %s: %w", path, err)
+	}
+	return os.WriteFile(path, formatted, 0644)
+}
+
+var genTmpl = template.Must(template.New("iapgen").Funcs(template.FuncMap{
+	"widthType": func(w int) string {
+		switch w {
+		case 1:
+			return "uint8"
+		case 2:
+			return "uint16"
+		default:
+			return "uint32"
+		}
+	},
+}).Parse(`
+import (
+{{- if .NeedsBinary}}
+	"encoding/binary"
+{{- end}}
+	"io"
+)
+
+// MarshalIAP encodes v to the iAP wire format.
+func (v *{{.Type}}) MarshalIAP(w io.Writer) error {
+{{- range .Fields}}
+{{- if eq .Variable "nul"}}
+	if _, err := w.Write([]byte(v.{{.Name}})); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{0}); err != nil {
+		return err
+	}
+{{- else if eq .Variable "lenprefix"}}
+	if err := binary.Write(w, binary.BigEndian, {{widthType .Width}}(len(v.{{.Name}}))); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(v.{{.Name}})); err != nil {
+		return err
+	}
+{{- else if eq .Variable "count"}}
+	for i := range v.{{.Name}} {
+		if err := v.{{.Name}}[i].MarshalIAP(w); err != nil {
+			return err
+		}
+	}
+{{- else if .Nested}}
+	if err := v.{{.Name}}.MarshalIAP(w); err != nil {
+		return err
+	}
+{{- else}}
+	if err := binary.Write(w, binary.BigEndian, v.{{.Name}}); err != nil {
+		return err
+	}
+{{- end}}
+{{- end}}
+	return nil
+}
+
+// UnmarshalIAP decodes v from the iAP wire format.
+func (v *{{.Type}}) UnmarshalIAP(r io.Reader) error {
+{{- range .Fields}}
+{{- if eq .Variable "nul"}}
+	{
+		var b []byte
+		var c [1]byte
+		for {
+			if _, err := io.ReadFull(r, c[:]); err != nil {
+				return err
+			}
+			if c[0] == 0 {
+				break
+			}
+			b = append(b, c[0])
+		}
+		v.{{.Name}} = string(b)
+	}
+{{- else if eq .Variable "lenprefix"}}
+	{
+		var n {{widthType .Width}}
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return err
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return err
+		}
+		v.{{.Name}} = {{if eq .GoType "string"}}string(b){{else}}b{{end}}
+	}
+{{- else if eq .Variable "count"}}
+	v.{{.Name}} = make([]{{.Elem}}, v.{{.CountOf}})
+	for i := range v.{{.Name}} {
+		if err := v.{{.Name}}[i].UnmarshalIAP(r); err != nil {
+			return err
+		}
+	}
+{{- else if .Nested}}
+	if err := v.{{.Name}}.UnmarshalIAP(r); err != nil {
+		return err
+	}
+{{- else}}
+	if err := binary.Read(r, binary.BigEndian, &v.{{.Name}}); err != nil {
+		return err
+	}
+{{- end}}
+{{- end}}
+	return nil
+}
+
+// IAPSize returns the marshaled size of v in bytes.
+func (v *{{.Type}}) IAPSize() int {
+	size := 0
+{{- range .Fields}}
+{{- if eq .Variable "nul"}}
+	size += len(v.{{.Name}}) + 1
+{{- else if eq .Variable "lenprefix"}}
+	size += {{.Width}} + len(v.{{.Name}})
+{{- else if eq .Variable "count"}}
+	for i := range v.{{.Name}} {
+		size += v.{{.Name}}[i].IAPSize()
+	}
+{{- else if .Nested}}
+	size += v.{{.Name}}.IAPSize()
+{{- else}}
+	size += binary.Size(v.{{.Name}})
+{{- end}}
+{{- end}}
+	return size
+}
+`))
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestGenerate exercises the generator end-to-end against a lingos group
+// covering three field shapes that have each previously produced generated
+// code that compiled but was wrong, or didn't compile at all: a nested
+// struct reached only through a `count=` field (missing
+// MarshalIAP/UnmarshalIAP/IAPSize on the nested type), a bare
+// NUL-terminated string with no other variable fields (an unused
+// "encoding/binary" import), and a plain (non-slice) nested struct field
+// (silently falling back to encoding/binary instead of calling the
+// field's own generated methods). It builds the generated output and
+// round-trips it, rather than just checking it parses.
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+
+	const src = `package testlingos
+
+type extRemoteLingos struct {
+	GetIndexedPlayingTrackInfo GetIndexedPlayingTrackInfo ` + "`id:\"0x02\"`" + `
+	SetName                    SetName                    ` + "`id:\"0x03\"`" + `
+	SendHeader                 SendHeaderPayload          ` + "`id:\"0x04\"`" + `
+}
+
+type TrackInfo struct {
+	Index uint32
+}
+
+type GetIndexedPlayingTrackInfo struct {
+	Count uint32
+	Rows  []TrackInfo ` + "`iap:\"count=Count\"`" + `
+}
+
+type SetName struct {
+	Name string ` + "`iap:\"nul\"`" + `
+}
+
+type SubHeader struct {
+	Something string ` + "`iap:\"nul\"`" + `
+}
+
+type SendHeaderPayload struct {
+	Header SubHeader
+	Name   string ` + "`iap:\"nul\"`" + `
+}
+`
+
+	if err := os.WriteFile(filepath.Join(dir, "lingos.go"), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run(dir); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	for _, want := range []string{
+		"getindexedplayingtrackinfo_iapgen.go",
+		"setname_iapgen.go",
+		"trackinfo_iapgen.go",
+		"sendheaderpayload_iapgen.go",
+		"subheader_iapgen.go",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, want)); err != nil {
+			t.Errorf("expected generated file %s: %v", want, err)
+		}
+	}
+
+	goMod := "module testlingos\n\ngo 1.21\n"
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(goMod), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	const roundTripSrc = `package testlingos
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	p := SendHeaderPayload{Header: SubHeader{Something: "hi"}, Name: "bob"}
+
+	want := len("hi") + 1 + len("bob") + 1
+	if got := p.IAPSize(); got != want {
+		t.Fatalf("IAPSize() = %d, want %d", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := p.MarshalIAP(&buf); err != nil {
+		t.Fatalf("MarshalIAP: %v", err)
+	}
+
+	var got SendHeaderPayload
+	if err := got.UnmarshalIAP(&buf); err != nil {
+		t.Fatalf("UnmarshalIAP: %v", err)
+	}
+	if got != p {
+		t.Fatalf("round trip mismatch: got %+v, want %+v", got, p)
+	}
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "roundtrip_test.go"), []byte(roundTripSrc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skipf("go toolchain not available: %v", err)
+	}
+	cmd := exec.Command(goBin, "test", "./...")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generated package failed to build/test: %v\n%s", err, out)
+	}
+}
@@ -2,6 +2,7 @@ package ipod
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -90,9 +91,36 @@ func parseIdTag(tag *reflect.StructTag) (uint16, error) {
 var mIDToType = make(map[LingoCmdID][]reflect.Type)
 var mTypeToID = make(map[reflect.Type]LingoCmdID)
 
-func storeMapping(cmd LingoCmdID, t reflect.Type) {
-	mIDToType[cmd] = append(mIDToType[cmd], t)
+var iapSizerType = reflect.TypeOf((*IAPSizer)(nil)).Elem()
+
+// implementsIAPSizer reports whether *t implements IAPSizer, i.e. whether
+// t is a generated, variable-size payload.
+func implementsIAPSizer(t reflect.Type) bool {
+	return reflect.PtrTo(t).Implements(iapSizerType)
+}
+
+func anyImplementsIAPSizer(types []reflect.Type) bool {
+	for _, t := range types {
+		if implementsIAPSizer(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// storeMapping registers t under cmd. A variable-size (IAPSizer) payload
+// must be the sole registrant for its LingoCmdID: Lookup disambiguates
+// same-ID payloads by comparing a candidate's marshaled size against the
+// wire payload size, but a variable-size payload only has a size once
+// it's decoded, so a zero-value instance can't be compared this way.
+func storeMapping(cmd LingoCmdID, t reflect.Type) error {
+	existing := mIDToType[cmd]
+	if len(existing) > 0 && (implementsIAPSizer(t) || anyImplementsIAPSizer(existing)) {
+		return fmt.Errorf("register lingos: %v already has %d payload(s) registered; variable-size payload %v must be the sole registrant for its LingoCmdID", cmd, len(existing), t)
+	}
+	mIDToType[cmd] = append(existing, t)
 	mTypeToID[t] = cmd
+	return nil
 }
 
 // RegisterLingos registers a set of lingo commands
@@ -106,7 +134,9 @@ func RegisterLingos(lingoID uint8, m interface{}) error {
 			return fmt.Errorf("register lingos: parse id tag err: %v", err)
 		}
 
-		storeMapping(NewLingoCmdID(uint16(lingoID), cmdID), cmd.Type)
+		if err := storeMapping(NewLingoCmdID(uint16(lingoID), cmdID), cmd.Type); err != nil {
+			return err
+		}
 	}
 	return nil
 
@@ -152,14 +182,47 @@ type LookupResult struct {
 	Transaction bool
 }
 
+// IAPSizer is implemented by generated payload types with variable-length
+// fields to report their exact marshaled size (see storeMapping).
+type IAPSizer interface {
+	IAPSize() int
+}
+
+// IAPMarshaler is implemented by generated payload types to encode
+// themselves to the iAP wire format.
+type IAPMarshaler interface {
+	MarshalIAP(w io.Writer) error
+}
+
+// IAPUnmarshaler is implemented by generated payload types to decode
+// themselves from the iAP wire format.
+type IAPUnmarshaler interface {
+	UnmarshalIAP(r io.Reader) error
+}
+
 // Lookup finds a the payload by LingoCmdID using payloadSize as a hint
 func Lookup(id LingoCmdID, payloadSize int, defaultTrxEnabled bool) (LookupResult, bool) {
+	return LookupContext(context.Background(), id, payloadSize, defaultTrxEnabled)
+}
+
+// LookupContext is Lookup, additionally reporting a LookupMiss to ctx's
+// Tracer (see RegisterTracer/WithTracer) whenever no registered payload
+// matches id and payloadSize.
+func LookupContext(ctx context.Context, id LingoCmdID, payloadSize int, defaultTrxEnabled bool) (LookupResult, bool) {
 	payloads, ok := mIDToType[id]
 	if !ok {
+		TracerFromContext(ctx).LookupMiss(id, payloadSize)
 		return LookupResult{}, false
 	}
 	for _, p := range payloads {
 		v := reflect.New(p).Interface()
+		if _, ok := v.(IAPSizer); ok {
+			// Variable-size payloads can't be disambiguated by comparing a
+			// zero-value instance's size (see IAPSizer); RegisterLingos
+			// guarantees they're the sole registrant for id, so they're
+			// handled by the single-registrant fallback below instead.
+			continue
+		}
 		cmdSize := binarySize(v)
 		if cmdSize == -1 {
 			continue
@@ -186,6 +249,7 @@ func Lookup(id LingoCmdID, payloadSize int, defaultTrxEnabled bool) (LookupResul
 		}, true
 	}
 
+	TracerFromContext(ctx).LookupMiss(id, payloadSize)
 	return LookupResult{}, false
 }
 
@@ -0,0 +1,97 @@
+package ipod
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+// variablePayload is a minimal variable-size payload (implements IAPSizer)
+// standing in for a cmd/lingogen-generated type, so these tests don't
+// depend on generated code.
+type variablePayload struct {
+	S string
+}
+
+func (v *variablePayload) IAPSize() int { return len(v.S) + 1 }
+
+func (v *variablePayload) MarshalIAP(w io.Writer) error {
+	if _, err := w.Write([]byte(v.S)); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte{0})
+	return err
+}
+
+func (v *variablePayload) UnmarshalIAP(r io.Reader) error {
+	var b []byte
+	var c [1]byte
+	for {
+		if _, err := io.ReadFull(r, c[:]); err != nil {
+			return err
+		}
+		if c[0] == 0 {
+			break
+		}
+		b = append(b, c[0])
+	}
+	v.S = string(b)
+	return nil
+}
+
+// fixedPayload is a plain fixed-size payload with no IAPSizer.
+type fixedPayload struct {
+	N uint32
+}
+
+func TestRegisterLingosRejectsSecondRegistrantForVariableSizeID(t *testing.T) {
+	type group1 struct {
+		A variablePayload `id:"0x01"`
+	}
+	type group2 struct {
+		B fixedPayload `id:"0x01"`
+	}
+
+	const lingoID = 0xF0
+	if err := RegisterLingos(lingoID, group1{}); err != nil {
+		t.Fatalf("register variable-size payload: %v", err)
+	}
+	if err := RegisterLingos(lingoID, group2{}); err == nil {
+		t.Fatal("expected an error registering a second payload alongside a variable-size one")
+	}
+}
+
+func TestLookupResolvesSoleVariableSizeRegistrant(t *testing.T) {
+	type group struct {
+		P variablePayload `id:"0x02"`
+	}
+	const lingoID = 0xF1
+	if err := RegisterLingos(lingoID, group{}); err != nil {
+		t.Fatal(err)
+	}
+
+	id := NewLingoCmdID(lingoID, 0x02)
+	result, ok := Lookup(id, 6, true)
+	if !ok {
+		t.Fatalf("Lookup(%v, ...) = _, false; want true", id)
+	}
+	if _, ok := result.Payload.(*variablePayload); !ok {
+		t.Fatalf("Payload = %T, want *variablePayload", result.Payload)
+	}
+	if !result.Transaction {
+		t.Fatal("Transaction = false, want true (defaultTrxEnabled)")
+	}
+}
+
+func TestLookupContextReportsLookupMiss(t *testing.T) {
+	tr := &recordingTracer{}
+	ctx := WithTracer(context.Background(), tr)
+
+	id := NewLingoCmdID(0xF3, 0x01) // never registered
+	if _, ok := LookupContext(ctx, id, 4, false); ok {
+		t.Fatalf("LookupContext(%v, ...) = _, true; want false", id)
+	}
+	if len(tr.misses) != 1 || tr.misses[0] != id {
+		t.Fatalf("misses = %v, want [%v]", tr.misses, id)
+	}
+}
@@ -0,0 +1,147 @@
+package ipod
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Direction indicates whether a command is being sent to or received from
+// the accessory.
+type Direction int
+
+const (
+	// DirectionOut is a command being written to the device.
+	DirectionOut Direction = iota
+	// DirectionIn is a command being read from the device.
+	DirectionIn
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionOut:
+		return "out"
+	case DirectionIn:
+		return "in"
+	default:
+		return "unknown"
+	}
+}
+
+// Span is an opaque handle returned by Tracer.StartCommand and passed back
+// to Tracer.EndCommand. ipod never inspects it.
+type Span interface{}
+
+// Tracer lets callers observe lingo command dispatch without patching ipod
+// itself. Register one globally with RegisterTracer, or attach one to a
+// context with WithTracer to scope it to a single connection.
+type Tracer interface {
+	// StartCommand is called before a command is marshaled or unmarshaled.
+	StartCommand(ctx context.Context, id LingoCmdID, dir Direction) (context.Context, Span)
+	// EndCommand is called once the command has been fully written or read.
+	EndCommand(span Span, err error)
+	// LookupMiss is called when Lookup/LookupContext can't find a
+	// registered payload type for id.
+	LookupMiss(id LingoCmdID, payloadSize int)
+}
+
+type noopTracer struct{}
+
+func (noopTracer) StartCommand(ctx context.Context, id LingoCmdID, dir Direction) (context.Context, Span) {
+	return ctx, nil
+}
+
+func (noopTracer) EndCommand(span Span, err error) {}
+
+func (noopTracer) LookupMiss(id LingoCmdID, payloadSize int) {}
+
+var globalTracer Tracer = noopTracer{}
+
+// RegisterTracer sets the default Tracer, used when a context carries none
+// (see WithTracer). Passing nil restores the no-op default.
+func RegisterTracer(t Tracer) {
+	if t == nil {
+		t = noopTracer{}
+	}
+	globalTracer = t
+}
+
+type tracerCtxKey struct{}
+
+// WithTracer attaches a Tracer to ctx, overriding the global tracer for any
+// Lookup/marshal calls made with it.
+func WithTracer(ctx context.Context, t Tracer) context.Context {
+	return context.WithValue(ctx, tracerCtxKey{}, t)
+}
+
+// TracerFromContext returns the Tracer attached to ctx via WithTracer, or
+// the global tracer registered with RegisterTracer if ctx carries none.
+func TracerFromContext(ctx context.Context) Tracer {
+	if t, ok := ctx.Value(tracerCtxKey{}).(Tracer); ok {
+		return t
+	}
+	return globalTracer
+}
+
+// MarshalLingoCmdIDContext writes id to w, reporting a StartCommand/EndCommand
+// pair to ctx's Tracer around the write. This only covers the 2-3 byte
+// header; prefer MarshalCommandContext when a payload is involved.
+func MarshalLingoCmdIDContext(ctx context.Context, w io.Writer, id LingoCmdID) error {
+	ctx, span := TracerFromContext(ctx).StartCommand(ctx, id, DirectionOut)
+	err := marshalLingoCmdID(w, id)
+	TracerFromContext(ctx).EndCommand(span, err)
+	return err
+}
+
+// UnmarshalLingoCmdIDContext reads a LingoCmdID from r, reporting a
+// StartCommand/EndCommand pair to ctx's Tracer around the read (StartCommand
+// fires with the zero LingoCmdID, since the real id isn't known until the
+// read succeeds). This only covers the header; prefer UnmarshalCommandContext
+// when a payload is involved.
+func UnmarshalLingoCmdIDContext(ctx context.Context, r io.Reader) (LingoCmdID, error) {
+	ctx, span := TracerFromContext(ctx).StartCommand(ctx, 0, DirectionIn)
+	var id LingoCmdID
+	err := unmarshalLingoCmdID(r, &id)
+	TracerFromContext(ctx).EndCommand(span, err)
+	return id, err
+}
+
+// MarshalCommandContext writes id followed by payload's marshaled form to
+// w, reporting a single StartCommand/EndCommand pair to ctx's Tracer
+// around both.
+func MarshalCommandContext(ctx context.Context, w io.Writer, id LingoCmdID, payload IAPMarshaler) error {
+	ctx, span := TracerFromContext(ctx).StartCommand(ctx, id, DirectionOut)
+	err := marshalLingoCmdID(w, id)
+	if err == nil {
+		err = payload.MarshalIAP(w)
+	}
+	TracerFromContext(ctx).EndCommand(span, err)
+	return err
+}
+
+// UnmarshalCommandContext reads a LingoCmdID from r, looks up its registered
+// payload via LookupContext using payloadSize as a hint, and decodes the
+// payload's wire form from r, all under a single StartCommand/EndCommand
+// span (see UnmarshalLingoCmdIDContext for why it starts with the zero
+// LingoCmdID). Payloads that don't implement IAPUnmarshaler are returned
+// undecoded for the caller's own reflection-based decoding.
+func UnmarshalCommandContext(ctx context.Context, r io.Reader, payloadSize int, defaultTrxEnabled bool) (id LingoCmdID, result LookupResult, err error) {
+	ctx, span := TracerFromContext(ctx).StartCommand(ctx, 0, DirectionIn)
+	defer func() { TracerFromContext(ctx).EndCommand(span, err) }()
+
+	if err = unmarshalLingoCmdID(r, &id); err != nil {
+		return id, LookupResult{}, err
+	}
+
+	var ok bool
+	result, ok = LookupContext(ctx, id, payloadSize, defaultTrxEnabled)
+	if !ok {
+		err = fmt.Errorf("ipod: unmarshal command: no payload registered for %v", id)
+		return id, result, err
+	}
+
+	if u, ok := result.Payload.(IAPUnmarshaler); ok {
+		err = u.UnmarshalIAP(r)
+	}
+	return id, result, err
+}
@@ -0,0 +1,81 @@
+package ipod
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// recordingTracer records StartCommand/EndCommand/LookupMiss calls for
+// assertions; shared by lingo_test.go and tracer_test.go.
+type recordingTracer struct {
+	started []LingoCmdID
+	dirs    []Direction
+	ended   []error
+	misses  []LingoCmdID
+}
+
+func (t *recordingTracer) StartCommand(ctx context.Context, id LingoCmdID, dir Direction) (context.Context, Span) {
+	t.started = append(t.started, id)
+	t.dirs = append(t.dirs, dir)
+	return ctx, nil
+}
+
+func (t *recordingTracer) EndCommand(span Span, err error) {
+	t.ended = append(t.ended, err)
+}
+
+func (t *recordingTracer) LookupMiss(id LingoCmdID, payloadSize int) {
+	t.misses = append(t.misses, id)
+}
+
+func TestMarshalUnmarshalCommandContextTraceInOrder(t *testing.T) {
+	type group struct {
+		P variablePayload `id:"0x03"`
+	}
+	const lingoID = 0xF2
+	if err := RegisterLingos(lingoID, group{}); err != nil {
+		t.Fatal(err)
+	}
+	id := NewLingoCmdID(lingoID, 0x03)
+
+	tr := &recordingTracer{}
+	ctx := WithTracer(context.Background(), tr)
+
+	payload := &variablePayload{S: "hi"}
+	var buf bytes.Buffer
+	if err := MarshalCommandContext(ctx, &buf, id, payload); err != nil {
+		t.Fatalf("MarshalCommandContext: %v", err)
+	}
+	if len(tr.started) != 1 || tr.started[0] != id || tr.dirs[0] != DirectionOut {
+		t.Fatalf("StartCommand after marshal = %v/%v, want [%v]/[%v]", tr.started, tr.dirs, id, DirectionOut)
+	}
+	if len(tr.ended) != 1 || tr.ended[0] != nil {
+		t.Fatalf("EndCommand after marshal = %v, want [nil]", tr.ended)
+	}
+
+	gotID, result, err := UnmarshalCommandContext(ctx, &buf, buf.Len(), false)
+	if err != nil {
+		t.Fatalf("UnmarshalCommandContext: %v", err)
+	}
+	if gotID != id {
+		t.Fatalf("id = %v, want %v", gotID, id)
+	}
+	got, ok := result.Payload.(*variablePayload)
+	if !ok {
+		t.Fatalf("Payload = %T, want *variablePayload", result.Payload)
+	}
+	if got.S != payload.S {
+		t.Fatalf("S = %q, want %q", got.S, payload.S)
+	}
+
+	// The unmarshal span starts with the zero LingoCmdID: the real id
+	// isn't known until the header has been read (see
+	// UnmarshalCommandContext's doc comment).
+	if len(tr.started) != 2 || tr.started[1] != 0 || tr.dirs[1] != DirectionIn {
+		t.Fatalf("StartCommand after unmarshal = %v/%v, want second entry 0/%v", tr.started, tr.dirs, DirectionIn)
+	}
+	if len(tr.ended) != 2 || tr.ended[1] != nil {
+		t.Fatalf("EndCommand after unmarshal = %v, want [nil nil]", tr.ended)
+	}
+}